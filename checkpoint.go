@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+)
+
+var resumeFile string
+var cliSeed int64
+
+func init() {
+	flag.StringVar(&resumeFile, "resume", "", "resume from a checkpoint `file` written by a previous incomplete run")
+	flag.Int64Var(&cliSeed, "seed", 1, "base RNG `seed`; each frame is seeded from a hash of (frame number, seed)")
+}
+
+// checkpointData is the sidecar written next to each incremental PNG so
+// an interrupted -i -1 run can be resumed without redrawing every stroke
+// from scratch.
+type checkpointData struct {
+	Frame    int
+	Iter     int // next iteration to run on resume, not the last one completed
+	RNGState uint64
+	W, H     int
+	Img1Pix  []byte
+	Img2Pix  []byte
+	OptsHash [32]byte
+}
+
+// optsHash fingerprints the CLI options that affect how a frame is
+// sketched, so resuming under different flags than the checkpoint was
+// written with is at least detectable.
+func optsHash() [32]byte {
+	s := fmt.Sprintf("shape=%s width=%d metric=%s lineLen=%d saliency=%v tangent=%v palUniq=%v",
+		shape, strokeWidth, metric, lineLen, saliencyWeight, tangentBias, palUniq)
+	return sha256.Sum256([]byte(s))
+}
+
+// writeCheckpoint saves enough state to resume sketching img1/img2 of
+// frame at iteration iter: the RNG state (so the exact same stroke
+// sequence continues), the pixel buffers, and a fingerprint of the CLI
+// options in effect. iter must be the next iteration to run, not the
+// last one completed, or resuming replays it against RNG draws it has
+// already consumed.
+func writeCheckpoint(name string, frame, iter int, r *rng, img1, img2 *image.RGBA) {
+	data := checkpointData{
+		Frame:    frame,
+		Iter:     iter,
+		RNGState: r.state,
+		W:        img1.Bounds().Dx(),
+		H:        img1.Bounds().Dy(),
+		Img1Pix:  append([]byte(nil), img1.Pix...),
+		Img2Pix:  append([]byte(nil), img2.Pix...),
+		OptsHash: optsHash(),
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&data); err != nil {
+		log.Fatalln(err)
+	}
+	if err := os.WriteFile(name+".ckpt", buf.Bytes(), 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// readCheckpoint loads a checkpoint written by writeCheckpoint, warning
+// if it was written under different CLI options than this run's.
+func readCheckpoint(name string) *checkpointData {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var data checkpointData
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		log.Fatalln(err)
+	}
+	if data.OptsHash != optsHash() {
+		log.Println("warning: checkpoint was written with different options than this run's; resuming anyway")
+	}
+	return &data
+}
+
+// imageFromPix wraps raw RGBA pixel bytes from a checkpoint back into an
+// *image.RGBA of size w x h.
+func imageFromPix(pix []byte, w, h int) *image.RGBA {
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: w * 4,
+		Rect:   image.Rect(0, 0, w, h),
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"math"
+)
+
+var metric string
+
+func init() {
+	flag.StringVar(&metric, "metric", "rgb", "color difference `metric`: rgb, cosine, lab, or lab94")
+}
+
+// srgbToLinearLUT maps an 8-bit sRGB channel value to its linear-light
+// equivalent in [0,1], via the standard sRGB gamma-expansion curve.
+var srgbToLinearLUT = func() [256]float64 {
+	var lut [256]float64
+	for i := range lut {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			lut[i] = c / 12.92
+		} else {
+			lut[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return lut
+}()
+
+// D65 whitepoint tristimulus values, used to normalize XYZ before the
+// Lab nonlinearity is applied.
+const (
+	d65WhiteX = 95.047
+	d65WhiteY = 100.0
+	d65WhiteZ = 108.883
+)
+
+// rgbToXYZ converts an sRGB byte triple to CIE XYZ (D65), scaled to the
+// 0-100 range conventional for Lab conversion.
+func rgbToXYZ(r, g, b uint8) (x, y, z float64) {
+	R := srgbToLinearLUT[r]
+	G := srgbToLinearLUT[g]
+	B := srgbToLinearLUT[b]
+	x = (0.4124*R + 0.3576*G + 0.1805*B) * 100
+	y = (0.2126*R + 0.7152*G + 0.0722*B) * 100
+	z = (0.0193*R + 0.1192*G + 0.9505*B) * 100
+	return
+}
+
+// labF is the CIE Lab nonlinearity applied to each whitepoint-normalized
+// XYZ component.
+func labF(t float64) float64 {
+	const delta3 = 216.0 / 24389.0
+	if t > delta3 {
+		return math.Cbrt(t)
+	}
+	return (841.0/108.0)*t + 4.0/29.0
+}
+
+// rgbToLab converts an sRGB byte triple to CIE Lab under the D65
+// illuminant.
+func rgbToLab(r, g, b uint8) (l, a, bb float64) {
+	x, y, z := rgbToXYZ(r, g, b)
+	fx := labF(x / d65WhiteX)
+	fy := labF(y / d65WhiteY)
+	fz := labF(z / d65WhiteZ)
+	l = 116*fy - 16
+	a = 500 * (fx - fy)
+	bb = 200 * (fy - fz)
+	return
+}
+
+// srcLab holds the cached Lab planes of the source image passed to
+// sketch (L, a, b interleaved per pixel), so the inner convergence loop
+// never repeats the source's color conversion.
+var srcLab []float32
+var srcLabW int
+var srcLabH int
+
+// precomputeLab fills srcLab with the Lab values of img, to be reused by
+// calcDiff for the rest of the frame.
+func precomputeLab(img *image.RGBA) {
+	w := img.Bounds().Dx()
+	h := img.Bounds().Dy()
+	min := img.Bounds().Min
+	srcLabW = w
+	srcLabH = h
+	srcLab = make([]float32, w*h*3)
+	i := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(min.X+x, min.Y+y)
+			l, a, b := rgbToLab(c.R, c.G, c.B)
+			srcLab[i] = float32(l)
+			srcLab[i+1] = float32(a)
+			srcLab[i+2] = float32(b)
+			i += 3
+		}
+	}
+}
+
+// srcLabAt returns the cached Lab of the source pixel at (x, y), or the
+// Lab of black for coordinates outside the image, matching how
+// image.RGBA.RGBAAt treats out-of-bounds pixels as zero. Strokes
+// routinely extend past the image edge, so this bounds check is load
+// bearing, not defensive.
+func srcLabAt(x, y int) (l, a, b float64) {
+	if x < 0 || x >= srcLabW || y < 0 || y >= srcLabH {
+		return 0, 0, 0
+	}
+	i := (y*srcLabW + x) * 3
+	return float64(srcLab[i]), float64(srcLab[i+1]), float64(srcLab[i+2])
+}
+
+// labDiff compares the cached Lab of img1 (the source image) against
+// img2's pixel at (x, y), converted on the fly, under distance function de.
+// img2.RGBAAt is already bounds-checked and returns zero for off-image
+// coordinates, so only srcLabAt needs its own check here.
+func labDiff(img1, img2 *image.RGBA, x, y int, de func(l1, a1, b1, l2, a2, b2 float64) float64) float64 {
+	l1, a1, b1 := srcLabAt(x, y)
+	c := img2.RGBAAt(x, y)
+	l2, a2, b2 := rgbToLab(c.R, c.G, c.B)
+	return de(l1, a1, b1, l2, a2, b2)
+}
+
+// deltaE76 is the CIE76 color difference: squared Euclidean distance in
+// Lab space.
+func deltaE76(l1, a1, b1, l2, a2, b2 float64) float64 {
+	dl := l1 - l2
+	da := a1 - a2
+	db := b1 - b2
+	return dl*dl + da*da + db*db
+}
+
+// deltaE94 is the CIE94 color difference, which weights the chroma and
+// hue components by the reference chroma to better match perceived
+// difference than plain Euclidean distance.
+func deltaE94(l1, a1, b1, l2, a2, b2 float64) float64 {
+	const k1, k2 = 0.045, 0.015
+	c1 := math.Sqrt(a1*a1 + b1*b1)
+	c2 := math.Sqrt(a2*a2 + b2*b2)
+	dl := l1 - l2
+	dc := c1 - c2
+	da := a1 - a2
+	db := b1 - b2
+	dh2 := da*da + db*db - dc*dc
+	if dh2 < 0 {
+		dh2 = 0
+	}
+	sc := 1 + k1*c1
+	sh := 1 + k2*c1
+	return dl*dl + (dc/sc)*(dc/sc) + dh2/(sh*sh)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+var gifOut string
+var gifDelay int
+var gifLoop int
+
+func init() {
+	flag.StringVar(&gifOut, "g", "", "write an animated `gif` of the finished frames instead of (or alongside) PNGs")
+	flag.IntVar(&gifDelay, "d", 10, "per-frame delay for -g output, in `hundredths` of a second")
+	flag.IntVar(&gifLoop, "loop", 0, "loop `count` for -g output (0 = loop forever)")
+}
+
+var gifW *gifWriter
+
+// appendGIFFrame quantizes img down to a 256-color palette derived from
+// pal and streams it straight to gifOut as the next frame of the
+// animated GIF started by -g. Unlike gif.EncodeAll, which needs every
+// frame in memory at once, gifWriter appends each frame's header, local
+// color table and LZW data as soon as it's ready, so a long -i -1 run's
+// memory use doesn't grow with the number of frames.
+func appendGIFFrame(img *image.RGBA, pal []color.RGBA) {
+	p := gifPalette(pal)
+	frame := image.NewPaletted(img.Bounds(), p)
+	draw.Draw(frame, frame.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	if gifW == nil {
+		w, err := newGIFWriter(gifOut, frame.Bounds().Dx(), frame.Bounds().Dy(), gifLoop)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		gifW = w
+	}
+	if err := gifW.writeFrame(frame, gifDelay); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// gifPalette reduces pal to at most 256 colors: first by deduplicating,
+// then by median-cut quantization if that still isn't enough.
+func gifPalette(pal []color.RGBA) color.Palette {
+	uniq := make(map[color.RGBA]bool, 256)
+	var dedup []color.RGBA
+	for _, c := range pal {
+		if !uniq[c] {
+			uniq[c] = true
+			dedup = append(dedup, c)
+			if len(dedup) > 256 {
+				break // must quantize anyway, stop deduping early
+			}
+		}
+	}
+	if len(dedup) <= 256 {
+		p := make(color.Palette, len(dedup))
+		for i, c := range dedup {
+			p[i] = c
+		}
+		return p
+	}
+	return medianCut(pal, 256)
+}
+
+// medianCut reduces colors to at most n entries by recursively splitting
+// the color set along its widest channel and averaging each resulting box.
+func medianCut(colors []color.RGBA, n int) color.Palette {
+	boxes := [][]color.RGBA{append([]color.RGBA(nil), colors...)}
+	for len(boxes) < n {
+		widest, widestRange, widestChan := -1, -1, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			for ch := 0; ch < 3; ch++ {
+				lo, hi := 255, 0
+				for _, c := range b {
+					v := rgbaChannel(c, ch)
+					if v < lo {
+						lo = v
+					}
+					if v > hi {
+						hi = v
+					}
+				}
+				if hi-lo > widestRange {
+					widest, widestRange, widestChan = i, hi-lo, ch
+				}
+			}
+		}
+		if widest == -1 {
+			break // no box left worth splitting
+		}
+		b := boxes[widest]
+		sort.Slice(b, func(i, j int) bool {
+			return rgbaChannel(b[i], widestChan) < rgbaChannel(b[j], widestChan)
+		})
+		mid := len(b) / 2
+		boxes[widest] = b[:mid]
+		boxes = append(boxes, b[mid:])
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		var r, g, bl, a int
+		for _, c := range b {
+			r += int(c.R)
+			g += int(c.G)
+			bl += int(c.B)
+			a += int(c.A)
+		}
+		n := len(b)
+		p[i] = color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)}
+	}
+	return p
+}
+
+func rgbaChannel(c color.RGBA, ch int) int {
+	switch ch {
+	case 0:
+		return int(c.R)
+	case 1:
+		return int(c.G)
+	default:
+		return int(c.B)
+	}
+}
+
+// gifWriter incrementally encodes a GIF89a to disk: the header and
+// looping extension go out once, up front, then each frame is appended
+// as soon as appendGIFFrame has it, and the trailer is written on
+// close. At most one frame's pixels are ever in memory at a time.
+type gifWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// newGIFWriter creates name and writes the GIF header, logical screen
+// descriptor (sized to the first frame) and the Netscape looping
+// extension. There is no global color table; every frame carries its
+// own local color table instead, since each frame is quantized
+// independently.
+func newGIFWriter(name string, width, height, loop int) (*gifWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	gw := &gifWriter{f: f, w: w}
+
+	if _, err := w.WriteString("GIF89a"); err != nil {
+		return nil, err
+	}
+	if err := writeUint16(w, uint16(width)); err != nil {
+		return nil, err
+	}
+	if err := writeUint16(w, uint16(height)); err != nil {
+		return nil, err
+	}
+	// packed = no global color table, background color index 0,
+	// square pixel aspect ratio.
+	if _, err := w.Write([]byte{0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if err := writeNetscapeLoop(w, loop); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func writeUint16(w io.Writer, v uint16) error {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// writeNetscapeLoop writes the de facto standard Application Extension
+// that tells viewers to loop the animation loop times (0 = forever).
+func writeNetscapeLoop(w io.Writer, loop int) error {
+	if _, err := w.Write([]byte{0x21, 0xFF, 0x0B}); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("NETSCAPE2.0")); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0x03, 0x01}); err != nil {
+		return err
+	}
+	if err := writeUint16(w, uint16(loop)); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+// colorTableBits returns the bit depth of a color table holding at
+// least n entries, clamped to what GIF's 3-bit size field can encode:
+// at least 2 bits (the LZW minimum code size floor), at most 8.
+func colorTableBits(n int) int {
+	bits := 2
+	for (1 << bits) < n {
+		bits++
+	}
+	if bits > 8 {
+		bits = 8
+	}
+	return bits
+}
+
+// writeFrame appends one frame: a graphic control extension carrying
+// the frame delay, an image descriptor with a local color table sized
+// to img's palette, and the LZW-compressed pixel indices.
+func (gw *gifWriter) writeFrame(img *image.Paletted, delayHundredths int) error {
+	bits := colorTableBits(len(img.Palette))
+	tableSize := 1 << bits
+
+	gce := []byte{0x21, 0xF9, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint16(gce[4:6], uint16(delayHundredths))
+	if _, err := gw.w.Write(gce); err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	desc := make([]byte, 10)
+	desc[0] = 0x2C
+	binary.LittleEndian.PutUint16(desc[5:7], uint16(b.Dx()))
+	binary.LittleEndian.PutUint16(desc[7:9], uint16(b.Dy()))
+	desc[9] = 0x80 | byte(bits-1) // local color table present, size field
+	if _, err := gw.w.Write(desc); err != nil {
+		return err
+	}
+
+	table := make([]byte, tableSize*3)
+	for i := 0; i < tableSize; i++ {
+		var c color.RGBA
+		if i < len(img.Palette) {
+			c = img.Palette[i].(color.RGBA)
+		}
+		table[i*3], table[i*3+1], table[i*3+2] = c.R, c.G, c.B
+	}
+	if _, err := gw.w.Write(table); err != nil {
+		return err
+	}
+
+	return writeLZWBlocks(gw.w, img.Pix, bits)
+}
+
+// writeLZWBlocks GIF-LZW-compresses pix at the given code size and
+// emits it as the sub-block stream the format expects: a minimum code
+// size byte, then one or more length-prefixed data blocks of up to 255
+// bytes, terminated by an empty block.
+func writeLZWBlocks(w io.Writer, pix []byte, litWidth int) error {
+	if _, err := w.Write([]byte{byte(litWidth)}); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	lzww := lzw.NewWriter(&buf, lzw.LSB, litWidth)
+	if _, err := lzww.Write(pix); err != nil {
+		return err
+	}
+	if err := lzww.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	for len(data) > 0 {
+		n := len(data)
+		if n > 255 {
+			n = 255
+		}
+		if _, err := w.Write([]byte{byte(n)}); err != nil {
+			return err
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+// close writes the GIF trailer and flushes the file to disk.
+func (gw *gifWriter) close() error {
+	if _, err := gw.w.Write([]byte{0x3B}); err != nil {
+		return err
+	}
+	if err := gw.w.Flush(); err != nil {
+		return err
+	}
+	return gw.f.Close()
+}
+
+// writeGIF finalizes the animated GIF started by -g. Frames were
+// already streamed to disk as they finished, so this only writes the
+// trailer and closes the file.
+func writeGIF() {
+	if gifW == nil {
+		return
+	}
+	if err := gifW.close(); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("wrote", gifOut)
+}
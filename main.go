@@ -14,22 +14,50 @@ OPTIONS
 	-1 num
 	      number of first frame (default 1)
 	-P    parallelize (slower on short lines)
+	-d hundredths
+	      per-frame delay for -g output, in hundredths of a second (default 10)
+	-g gif
+	      write an animated gif of the finished frames instead of (or alongside) PNGs
 	-i iter
 	      number of iterations (-1 for infinite) (default 5000000)
 	-l len
 	      line length limit (default 40)
+	-loop count
+	      loop count for -g output (0 = loop forever)
+	-metric metric
+	      color difference metric: rgb, cosine, lab, or lab94 (default rgb)
 	-n frames
 	      number of input frames to sketch
 	-p    remove duplicate colors from palette
+	-resume file
+	      resume from a checkpoint file written by a previous incomplete run
+	      (not supported together with -workers > 1)
 	-s sec
 	      interval between incremental saves, in seconds (default -1)
+	-saliency weight
+	      blend weight in [0,1] between uniform and edge-saliency-weighted stroke placement (default 0)
+	-seed seed
+	      base RNG seed; each frame is seeded from a hash of (frame number, seed) (default 1)
+	-shape shape
+	      stroke shape: line, thick, aa, rect, or ellipse (default line)
 	-t sec
 	      statistics reporting interval, in seconds (default 1)
+	-tangent
+	      bias stroke direction to align with local image contours
+	-tile size
+	      tile size, in pixels, for -workers > 1 (default 64)
+	-width width
+	      stroke width, for -shape thick (default 3)
+	-workers worker
+	      number of tile workers for tile-parallel iteration (default 1, single-threaded);
+	      only -shape line and rect can run tile-parallel, other shapes fall back to single-threaded
 
 EXAMPLES
 	ffmpeg -i input.webm input%03d.png
 	sketch input*.png
 	ffmpeg -i frame%03d.png -c:v vp8 output.webm
+
+	sketch -g out.gif input*.png
 */
 package main
 
@@ -44,145 +72,67 @@ import (
 	"image/png"
 	"log"
 	"math"
-	"math/rand"
 	"os"
 	"sync"
 	"time"
 )
 
-func bdiff(a, b *image.RGBA, x0, y0, x1, y1 int) float64 {
-	dx, dy := x1-x0, y1-y0
-	if dx < 0 {
-		dx = -dx
-	}
-	if dy < 0 {
-		dy = -dy
-	}
-	sx, sy := -1, -1
-	if x0 < x1 {
-		sx = 1
-	}
-	if y0 < y1 {
-		sy = 1
-	}
-	err := dx - dy
-
-	var dif float64
-	for {
-		dif += calcDiff(a, b, x0, y0)
-		if x0 == x1 && y0 == y1 {
-			return dif
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
+// calcDiff measures how different the pixel at (x, y) is between img1
+// and img2, under the metric selected by -metric.
+func calcDiff(img1, img2 *image.RGBA, x, y int) float64 {
+	switch metric {
+	case "lab":
+		return labDiff(img1, img2, x, y, deltaE76)
+	case "lab94":
+		return labDiff(img1, img2, x, y, deltaE94)
+	case "cosine":
+		return cosineDiff(img1, img2, x, y)
+	default:
+		return rgbDiff(img1, img2, x, y)
 	}
 }
 
-func calcDiff(img1, img2 *image.RGBA, x, y int) float64 {
+func rgbDiff(img1, img2 *image.RGBA, x, y int) float64 {
 	a := img1.RGBAAt(x, y)
 	b := img2.RGBAAt(x, y)
 	A := [4]float64{float64(a.R), float64(a.G), float64(a.B), float64(a.A)}
 	B := [4]float64{float64(b.R), float64(b.G), float64(b.B), float64(b.A)}
-	if true {
-		x := (B[0] - A[0]) * (B[0] - A[0])
-		x += (B[1] - A[1]) * (B[1] - A[1])
-		x += (B[2] - A[2]) * (B[2] - A[2])
-		x += (B[3] - A[3]) * (B[3] - A[3])
-		return x
-	} else {
-		// cosine
-		x := A[0] * A[0]
-		y := B[0] * B[0]
-		z := A[0] * B[0]
-
-		x += A[1] * A[1]
-		y += B[1] * B[1]
-		z += A[1] * B[1]
-
-		x += A[2] * A[2]
-		y += B[2] * B[2]
-		z += A[2] * B[2]
-
-		x += A[3] * A[3]
-		y += B[3] * B[3]
-		z += A[3] * B[3]
-		return 1 - z/(math.Sqrt(x)*math.Sqrt(y))
-	}
-}
-
-func bcopy(dst, src *image.RGBA, x0, y0, x1, y1 int) {
-	dx, dy := x1-x0, y1-y0
-	if dx < 0 {
-		dx = -dx
-	}
-	if dy < 0 {
-		dy = -dy
-	}
-	sx, sy := -1, -1
-	if x0 < x1 {
-		sx = 1
-	}
-	if y0 < y1 {
-		sy = 1
-	}
-	err := dx - dy
-
-	for {
-		dst.SetRGBA(x0, y0, src.RGBAAt(x0, y0))
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
-	}
+	x2 := (B[0] - A[0]) * (B[0] - A[0])
+	x2 += (B[1] - A[1]) * (B[1] - A[1])
+	x2 += (B[2] - A[2]) * (B[2] - A[2])
+	x2 += (B[3] - A[3]) * (B[3] - A[3])
+	return x2
 }
 
-func line(img *image.RGBA, x0, y0, x1, y1 int, clr color.RGBA) {
-	dx, dy := x1-x0, y1-y0
-	if dx < 0 {
-		dx = -dx
-	}
-	if dy < 0 {
-		dy = -dy
-	}
-	sx, sy := -1, -1
-	if x0 < x1 {
-		sx = 1
-	}
-	if y0 < y1 {
-		sy = 1
-	}
-	err := dx - dy
+func cosineDiff(img1, img2 *image.RGBA, px, py int) float64 {
+	a := img1.RGBAAt(px, py)
+	b := img2.RGBAAt(px, py)
+	A := [4]float64{float64(a.R), float64(a.G), float64(a.B), float64(a.A)}
+	B := [4]float64{float64(b.R), float64(b.G), float64(b.B), float64(b.A)}
 
-	for {
-		img.SetRGBA(x0, y0, clr)
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
+	x := A[0] * A[0]
+	y := B[0] * B[0]
+	z := A[0] * B[0]
+
+	x += A[1] * A[1]
+	y += B[1] * B[1]
+	z += A[1] * B[1]
+
+	x += A[2] * A[2]
+	y += B[2] * B[2]
+	z += A[2] * B[2]
+
+	x += A[3] * A[3]
+	y += B[3] * B[3]
+	z += A[3] * B[3]
+	if x == 0 || y == 0 {
+		// Both vectors zero-length (e.g. off-image pixels on both
+		// sides) have no defined angle between them; treat as no
+		// difference rather than letting 0/0 poison the stroke's
+		// total diff with NaN.
+		return 0
 	}
+	return 1 - z/(math.Sqrt(x)*math.Sqrt(y))
 }
 
 var savewait sync.WaitGroup
@@ -217,6 +167,8 @@ var palUniq bool
 var saveDelay float64
 var statDelay float64
 var par bool
+var shape string
+var strokeWidth int
 
 func init() {
 	flag.IntVar(&maxIter, "i", 5e6, "number of `iter`ations (-1 for infinite)")
@@ -227,12 +179,31 @@ func init() {
 	flag.Float64Var(&saveDelay, "s", -1, "interval between incremental saves, in `sec`onds")
 	flag.Float64Var(&statDelay, "t", 1, "statistics reporting interval, in `sec`onds")
 	flag.BoolVar(&par, "P", false, "parallelize (slower on short lines)")
+	flag.StringVar(&shape, "shape", "line", "stroke `shape`: line, thick, aa, rect, or ellipse")
+	flag.IntVar(&strokeWidth, "width", 3, "stroke `width`, for -shape thick")
+}
+
+// newStroke builds the Stroke selected by -shape for the endpoints
+// (x1,y1)-(x2,y2).
+func newStroke(x1, y1, x2, y2 int) Stroke {
+	switch shape {
+	case "thick":
+		return thickStroke{x1, y1, x2, y2, strokeWidth}
+	case "aa":
+		return aaStroke{x1, y1, x2, y2}
+	case "rect":
+		return rectStroke{x1, y1, x2, y2}
+	case "ellipse":
+		return ellipseStroke{x1, y1, x2, y2}
+	default:
+		return lineStroke{x1, y1, x2, y2}
+	}
 }
 
 var incrSaveNum = 1 // when saving incrementally
 var saveNum = 1     // when saving finished frames
 
-func sketch(src image.Image) {
+func sketch(frame int, src image.Image) {
 	w := src.Bounds().Dx()
 	h := src.Bounds().Dy()
 
@@ -245,6 +216,10 @@ func sketch(src image.Image) {
 		draw.Draw(img, img.Bounds(), src, image.ZP, draw.Src)
 	}
 
+	if metric == "lab" || metric == "lab94" {
+		precomputeLab(img)
+	}
+
 	palette := make([]color.RGBA, 0, 1024*1024)
 	uniq := make(map[color.RGBA]bool, 50*1024)
 	for y := 0; y < h; y++ {
@@ -262,58 +237,115 @@ func sketch(src image.Image) {
 	}
 	log.Printf("%d colors in palette\n", len(palette))
 
+	r := newRNG(seedForFrame(frame, cliSeed))
+	iterStart := 0
+
+	if resumeFile != "" && workers > 1 {
+		log.Fatalf("-resume is not supported with -workers > 1: sketchTiles has no iterStart, so the checkpoint's progress would be silently discarded")
+	}
+
 	img1 := image.NewRGBA(img.Bounds())
 	img2 := image.NewRGBA(img.Bounds())
-	bg := color.RGBA{0, 0, 0, 255}
-	draw.Draw(img1, img1.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
-	draw.Draw(img2, img2.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	if resumeFile != "" {
+		ckName := resumeFile
+		resumeFile = "" // only the first frame processed resumes from it
+		ck := readCheckpoint(ckName)
+		r.state = ck.RNGState
+		iterStart = ck.Iter
+		copy(img1.Pix, imageFromPix(ck.Img1Pix, ck.W, ck.H).Pix)
+		copy(img2.Pix, imageFromPix(ck.Img2Pix, ck.W, ck.H).Pix)
+		log.Printf("resumed frame %d at iteration %d from %s", ck.Frame, ck.Iter, ckName)
+	} else {
+		bg := color.RGBA{0, 0, 0, 255}
+		draw.Draw(img1, img1.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+		draw.Draw(img2, img2.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	}
+
+	var sal *saliencyMap
+	if saliencyWeight > 0 || tangentBias {
+		sal = computeSaliency(img)
+	}
+
+	if workers > 1 && !tileSafeShape() {
+		log.Printf("-shape %s can draw outside its tile; ignoring -workers and running single-threaded", shape)
+	}
+
+	if workers > 1 && tileSafeShape() {
+		sketchTiles(img, img1, img2, palette, sal, r)
+		save(img2, fmt.Sprintf("frame%03d", saveNum))
+		saveNum++
+		if gifOut != "" {
+			appendGIFFrame(img2, palette)
+		}
+		return
+	}
 
 	var lastSaveTime = time.Now()
 	var lastStatTime = time.Now()
 	var stati int
 	var statc int
 
-	for i := 0; i < maxIter || maxIter < 0; i++ {
+	for i := iterStart; i < maxIter || maxIter < 0; i++ {
 		stati++
-		x1 := rand.Intn(w)
-		y1 := rand.Intn(h)
-		x2 := -lineLen/2 + x1 + rand.Intn(lineLen)
-		y2 := -lineLen/2 + y1 + rand.Intn(lineLen)
-		clr := palette[rand.Intn(len(palette))]
+		var x1, y1 int
+		if sal != nil && r.Float64() < saliencyWeight {
+			x1, y1 = sal.sample(r.Float64)
+		} else {
+			x1 = r.Intn(w)
+			y1 = r.Intn(h)
+		}
 
-		line(img1, x1, y1, x2, y2, clr)
+		var x2, y2 int
+		if tangentBias && sal != nil {
+			tx, ty := sal.tangent(x1, y1)
+			length := float64(r.Intn(lineLen) - lineLen/2)
+			x2 = x1 + int(math.Round(tx*length))
+			y2 = y1 + int(math.Round(ty*length))
+		} else {
+			x2 = -lineLen/2 + x1 + r.Intn(lineLen)
+			y2 = -lineLen/2 + y1 + r.Intn(lineLen)
+		}
+		clr := palette[r.Intn(len(palette))]
+
+		s := newStroke(x1, y1, x2, y2)
+		s.Draw(img1, clr)
 
 		var diffimg1, diffimg2 float64
 		if par {
 			var diffwait sync.WaitGroup
 			diffwait.Add(2)
 			go func() {
-				diffimg1 = bdiff(img, img1, x1, y1, x2, y2)
+				diffimg1 = s.Diff(img, img1)
 				diffwait.Done()
 			}()
 			go func() {
-				diffimg2 = bdiff(img, img2, x1, y1, x2, y2)
+				diffimg2 = s.Diff(img, img2)
 				diffwait.Done()
 			}()
 			diffwait.Wait()
 		} else {
-			diffimg1 = bdiff(img, img1, x1, y1, x2, y2)
-			diffimg2 = bdiff(img, img2, x1, y1, x2, y2)
+			diffimg1 = s.Diff(img, img1)
+			diffimg2 = s.Diff(img, img2)
 		}
 
 		if diffimg1 < diffimg2 {
 			// converges
-			bcopy(img2, img1, x1, y1, x2, y2)
+			s.CopyRegion(img2, img1)
 			statc++
 		} else {
 			// diverges
-			bcopy(img1, img2, x1, y1, x2, y2)
+			s.CopyRegion(img1, img2)
 		}
 		if i%50 == 0 { // time.Now was bottlenecking
 			now := time.Now()
 			dur := now.Sub(lastSaveTime)
 			if saveDelay > 0 && dur >= time.Duration(saveDelay)*time.Second {
-				save(img2, fmt.Sprintf("incr%03d", incrSaveNum))
+				name := fmt.Sprintf("incr%03d", incrSaveNum)
+				save(img2, name)
+				// i has already consumed its RNG draws and been
+				// applied to img1/img2, so the checkpoint's
+				// resume point is the next iteration, i+1.
+				writeCheckpoint(name, frame, i+1, r, img1, img2)
 				incrSaveNum++
 				lastSaveTime = time.Now()
 			}
@@ -331,11 +363,13 @@ func sketch(src image.Image) {
 
 	save(img2, fmt.Sprintf("frame%03d", saveNum))
 	saveNum++
+	if gifOut != "" {
+		appendGIFFrame(img2, palette)
+	}
 }
 
 func main() {
 	log.SetFlags(0)
-	rand.Seed(1)
 	flag.Parse()
 
 	frame := frameStart
@@ -354,9 +388,10 @@ func main() {
 			}
 			f.Close()
 
-			sketch(src)
+			sketch(frame, src)
 			frame++
 		}
+		writeGIF()
 		log.Println("end of frames")
 		return
 	}
@@ -378,8 +413,9 @@ func main() {
 		}
 		f.Close()
 
-		sketch(src)
+		sketch(frame, src)
 		frame++
 	}
+	writeGIF()
 	log.Print("end of frames")
 }
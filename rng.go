@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// rng is a small, self-contained xorshift64* pseudo-random source.
+// Unlike math/rand's default source, its entire state is a single
+// uint64, which checkpoint.go can persist and restore verbatim so a
+// resumed run reproduces the exact same stroke sequence.
+type rng struct {
+	state uint64
+}
+
+// newRNG creates an rng seeded with seed. A zero seed is remapped to 1,
+// since an all-zero xorshift state never produces anything but zero.
+func newRNG(seed uint64) *rng {
+	if seed == 0 {
+		seed = 1
+	}
+	return &rng{state: seed}
+}
+
+func (r *rng) next() uint64 {
+	x := r.state
+	x ^= x >> 12
+	x ^= x << 25
+	x ^= x >> 27
+	r.state = x
+	return x * 2685821657736338717
+}
+
+// Intn returns a pseudo-random number in [0, n).
+func (r *rng) Intn(n int) int {
+	if n <= 0 {
+		panic("rng: Intn called with n <= 0")
+	}
+	return int(r.next() % uint64(n))
+}
+
+// Float64 returns a pseudo-random number in [0, 1).
+func (r *rng) Float64() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+// seedForFrame derives a per-frame seed from a hash of (frame, seed), so
+// every frame draws an independent, individually reproducible stroke
+// sequence instead of all frames sharing one RNG stream.
+func seedForFrame(frame int, seed int64) uint64 {
+	h := fnv.New64a()
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[0:8], uint64(frame))
+	binary.LittleEndian.PutUint64(b[8:16], uint64(seed))
+	h.Write(b[:])
+	return h.Sum64()
+}
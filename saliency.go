@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"math"
+	"sort"
+)
+
+var saliencyWeight float64
+var tangentBias bool
+
+func init() {
+	flag.Float64Var(&saliencyWeight, "saliency", 0, "blend `weight` in [0,1] between uniform and edge-saliency-weighted stroke placement")
+	flag.BoolVar(&tangentBias, "tangent", false, "bias stroke direction to align with local image contours")
+}
+
+var sobelGx = [3][3]float64{{-1, 0, 1}, {-2, 0, 2}, {-1, 0, 1}}
+var sobelGy = [3][3]float64{{-1, -2, -1}, {0, 0, 0}, {1, 2, 1}}
+
+// saliencyMap holds the Sobel gradient of a source image, used to bias
+// stroke placement and orientation toward detailed regions.
+type saliencyMap struct {
+	w, h   int
+	gx, gy []float64 // gradient components, for tangent direction
+	cdf    []float64 // cumulative, normalized gradient magnitude over pixel index y*w+x
+	maxMag float64   // largest gradient magnitude, for sampleIn's rejection test
+}
+
+// computeSaliency runs a 3x3 Sobel filter over img's luminance and
+// builds a CDF over pixel index so sample can pick high-gradient pixels
+// more often via binary search.
+func computeSaliency(img *image.RGBA) *saliencyMap {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	gray := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			gray[y*w+x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+
+	at := func(x, y int) float64 {
+		if x < 0 {
+			x = 0
+		} else if x >= w {
+			x = w - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		return gray[y*w+x]
+	}
+
+	m := &saliencyMap{w: w, h: h, gx: make([]float64, w*h), gy: make([]float64, w*h), cdf: make([]float64, w*h)}
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy float64
+			for ky := -1; ky <= 1; ky++ {
+				for kx := -1; kx <= 1; kx++ {
+					v := at(x+kx, y+ky)
+					sx += sobelGx[ky+1][kx+1] * v
+					sy += sobelGy[ky+1][kx+1] * v
+				}
+			}
+			i := y*w + x
+			m.gx[i] = sx
+			m.gy[i] = sy
+			mag := math.Hypot(sx, sy)
+			if mag > m.maxMag {
+				m.maxMag = mag
+			}
+			sum += mag
+			m.cdf[i] = sum
+		}
+	}
+	if sum > 0 {
+		for i := range m.cdf {
+			m.cdf[i] /= sum
+		}
+	} else {
+		// A perfectly flat source has no gradient to weight by; leaving
+		// cdf all-zero would make sample's binary search land on the
+		// last pixel every time, so fall back to a uniform CDF instead.
+		for i := range m.cdf {
+			m.cdf[i] = float64(i+1) / float64(len(m.cdf))
+		}
+	}
+	return m
+}
+
+// sample picks a pixel weighted by gradient magnitude, binary-searching
+// a draw from randFloat (in [0,1)) against the CDF.
+func (m *saliencyMap) sample(randFloat func() float64) (x, y int) {
+	r := randFloat()
+	i := sort.Search(len(m.cdf), func(i int) bool { return m.cdf[i] >= r })
+	if i >= len(m.cdf) {
+		i = len(m.cdf) - 1
+	}
+	return i % m.w, i / m.w
+}
+
+// sampleIn picks a pixel within bounds weighted toward high gradient
+// magnitude, by rejection sampling against maxMag. bounds is assumed to
+// lie within m; unlike sample, it has no CDF to binary-search, since
+// building one per tile per proposal would cost more than the saving.
+// It gives up after a handful of tries and returns the last candidate,
+// so a flat tile doesn't loop.
+func (m *saliencyMap) sampleIn(bounds image.Rectangle, rnd *rng) (x, y int) {
+	w, h := bounds.Dx(), bounds.Dy()
+	for try := 0; ; try++ {
+		x = bounds.Min.X + rnd.Intn(w)
+		y = bounds.Min.Y + rnd.Intn(h)
+		if m.maxMag <= 0 || try >= 8 {
+			return x, y
+		}
+		i := y*m.w + x
+		if rnd.Float64() < math.Hypot(m.gx[i], m.gy[i])/m.maxMag {
+			return x, y
+		}
+	}
+}
+
+// tangent returns the unit vector perpendicular to the gradient at
+// (x, y), i.e. along the local contour rather than across it.
+func (m *saliencyMap) tangent(x, y int) (tx, ty float64) {
+	i := y*m.w + x
+	gx, gy := m.gx[i], m.gy[i]
+	length := math.Hypot(gx, gy)
+	if length == 0 {
+		return 1, 0
+	}
+	return -gy / length, gx / length
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Stroke is a drawable primitive that can render itself into an RGBA
+// image, copy its own footprint from one image into another, and report
+// how much replacing the footprint would change the match against a
+// reference image. Implementations must enumerate exactly the pixels
+// they touch so CopyRegion and Diff stay O(perimeter) rather than
+// O(bbox).
+type Stroke interface {
+	Draw(img *image.RGBA, clr color.RGBA)
+	CopyRegion(dst, src *image.RGBA)
+	Diff(a, b *image.RGBA) float64
+}
+
+// eachPixel walks the Bresenham line from (x0,y0) to (x1,y1), calling f
+// once per pixel touched, inclusive of both endpoints.
+func eachPixel(x0, y0, x1, y1 int, f func(x, y int)) {
+	dx, dy := x1-x0, y1-y0
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	sx, sy := -1, -1
+	if x0 < x1 {
+		sx = 1
+	}
+	if y0 < y1 {
+		sy = 1
+	}
+	err := dx - dy
+
+	for {
+		f(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// lineStroke is a single-pixel Bresenham line, the original primitive.
+type lineStroke struct {
+	x0, y0, x1, y1 int
+}
+
+func (s lineStroke) Draw(img *image.RGBA, clr color.RGBA) {
+	eachPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int) {
+		img.SetRGBA(x, y, clr)
+	})
+}
+
+func (s lineStroke) CopyRegion(dst, src *image.RGBA) {
+	eachPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int) {
+		dst.SetRGBA(x, y, src.RGBAAt(x, y))
+	})
+}
+
+func (s lineStroke) Diff(a, b *image.RGBA) float64 {
+	var dif float64
+	eachPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int) {
+		dif += calcDiff(a, b, x, y)
+	})
+	return dif
+}
+
+// thickStroke is a line rendered as width parallel 1-pixel lines offset
+// along the perpendicular of its direction.
+type thickStroke struct {
+	x0, y0, x1, y1 int
+	width          int
+}
+
+// eachOffset calls f once per parallel line offset (ox, oy) needed to
+// cover the stroke's width, centered on the centerline.
+func (s thickStroke) eachOffset(f func(ox, oy int)) {
+	dx, dy := float64(s.x1-s.x0), float64(s.y1-s.y0)
+	length := math.Hypot(dx, dy)
+	var px, py float64
+	if length > 0 {
+		px, py = -dy/length, dx/length // unit perpendicular
+	} else {
+		px, py = 1, 0
+	}
+	half := s.width / 2
+	for o := -half; o <= half; o++ {
+		f(int(math.Round(px*float64(o))), int(math.Round(py*float64(o))))
+	}
+}
+
+func (s thickStroke) Draw(img *image.RGBA, clr color.RGBA) {
+	s.eachOffset(func(ox, oy int) {
+		eachPixel(s.x0+ox, s.y0+oy, s.x1+ox, s.y1+oy, func(x, y int) {
+			img.SetRGBA(x, y, clr)
+		})
+	})
+}
+
+func (s thickStroke) CopyRegion(dst, src *image.RGBA) {
+	s.eachOffset(func(ox, oy int) {
+		eachPixel(s.x0+ox, s.y0+oy, s.x1+ox, s.y1+oy, func(x, y int) {
+			dst.SetRGBA(x, y, src.RGBAAt(x, y))
+		})
+	})
+}
+
+func (s thickStroke) Diff(a, b *image.RGBA) float64 {
+	var dif float64
+	s.eachOffset(func(ox, oy int) {
+		eachPixel(s.x0+ox, s.y0+oy, s.x1+ox, s.y1+oy, func(x, y int) {
+			dif += calcDiff(a, b, x, y)
+		})
+	})
+	return dif
+}
+
+// aaStroke is an anti-aliased line drawn with Xiaolin Wu's algorithm;
+// each touched pixel carries a coverage weight in [0,1] that is used to
+// alpha-blend the stroke color and to weight calcDiff.
+type aaStroke struct {
+	x0, y0, x1, y1 int
+}
+
+func fracPart(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+// eachAAPixel walks the line from (x0,y0) to (x1,y1) using Xiaolin Wu's
+// algorithm, calling f once per touched pixel with its coverage weight.
+func eachAAPixel(x0, y0, x1, y1 int, f func(x, y int, weight float64)) {
+	fx0, fy0, fx1, fy1 := float64(x0), float64(y0), float64(x1), float64(y1)
+	steep := math.Abs(fy1-fy0) > math.Abs(fx1-fx0)
+	if steep {
+		fx0, fy0 = fy0, fx0
+		fx1, fy1 = fy1, fx1
+	}
+	if fx0 > fx1 {
+		fx0, fx1 = fx1, fx0
+		fy0, fy1 = fy1, fy0
+	}
+	dx := fx1 - fx0
+	dy := fy1 - fy0
+	gradient := 1.0
+	if dx != 0 {
+		gradient = dy / dx
+	}
+
+	plot := func(x, y int, c float64) {
+		if steep {
+			f(y, x, c)
+		} else {
+			f(x, y, c)
+		}
+	}
+
+	xend := math.Round(fx0)
+	yend := fy0 + gradient*(xend-fx0)
+	xgap := 1 - fracPart(fx0+0.5)
+	xpx1 := int(xend)
+	ypx1 := int(math.Floor(yend))
+	plot(xpx1, ypx1, (1-fracPart(yend))*xgap)
+	plot(xpx1, ypx1+1, fracPart(yend)*xgap)
+	intery := yend + gradient
+
+	xend = math.Round(fx1)
+	yend = fy1 + gradient*(xend-fx1)
+	xgap = fracPart(fx1 + 0.5)
+	xpx2 := int(xend)
+	ypx2 := int(math.Floor(yend))
+	plot(xpx2, ypx2, (1-fracPart(yend))*xgap)
+	plot(xpx2, ypx2+1, fracPart(yend)*xgap)
+
+	for x := xpx1 + 1; x < xpx2; x++ {
+		plot(x, int(math.Floor(intery)), 1-fracPart(intery))
+		plot(x, int(math.Floor(intery))+1, fracPart(intery))
+		intery += gradient
+	}
+}
+
+// blendRGBA mixes clr into the pixel at (x, y) weighted by weight,
+// reading and writing through img's own bounds checks.
+func blendRGBA(img *image.RGBA, x, y int, clr color.RGBA, weight float64) {
+	if weight <= 0 {
+		return
+	}
+	if weight >= 1 {
+		img.SetRGBA(x, y, clr)
+		return
+	}
+	bg := img.RGBAAt(x, y)
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(b)*weight + float64(a)*(1-weight))
+	}
+	img.SetRGBA(x, y, color.RGBA{mix(bg.R, clr.R), mix(bg.G, clr.G), mix(bg.B, clr.B), mix(bg.A, clr.A)})
+}
+
+func (s aaStroke) Draw(img *image.RGBA, clr color.RGBA) {
+	eachAAPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int, weight float64) {
+		blendRGBA(img, x, y, clr, weight)
+	})
+}
+
+func (s aaStroke) CopyRegion(dst, src *image.RGBA) {
+	eachAAPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int, weight float64) {
+		dst.SetRGBA(x, y, src.RGBAAt(x, y))
+	})
+}
+
+func (s aaStroke) Diff(a, b *image.RGBA) float64 {
+	var dif float64
+	eachAAPixel(s.x0, s.y0, s.x1, s.y1, func(x, y int, weight float64) {
+		dif += weight * calcDiff(a, b, x, y)
+	})
+	return dif
+}
+
+// rectStroke is the outline of the axis-aligned rectangle with corners
+// (x0,y0) and (x1,y1).
+type rectStroke struct {
+	x0, y0, x1, y1 int
+}
+
+func (s rectStroke) eachPixel(f func(x, y int)) {
+	x0, x1 := s.x0, s.x1
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	y0, y1 := s.y0, s.y1
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	for x := x0; x <= x1; x++ {
+		f(x, y0)
+		f(x, y1)
+	}
+	for y := y0 + 1; y < y1; y++ {
+		f(x0, y)
+		f(x1, y)
+	}
+}
+
+func (s rectStroke) Draw(img *image.RGBA, clr color.RGBA) {
+	s.eachPixel(func(x, y int) { img.SetRGBA(x, y, clr) })
+}
+
+func (s rectStroke) CopyRegion(dst, src *image.RGBA) {
+	s.eachPixel(func(x, y int) { dst.SetRGBA(x, y, src.RGBAAt(x, y)) })
+}
+
+func (s rectStroke) Diff(a, b *image.RGBA) float64 {
+	var dif float64
+	s.eachPixel(func(x, y int) { dif += calcDiff(a, b, x, y) })
+	return dif
+}
+
+// ellipseStroke is the outline of the ellipse inscribed in the bounding
+// box with corners (x0,y0) and (x1,y1), drawn with the midpoint ellipse
+// algorithm.
+type ellipseStroke struct {
+	x0, y0, x1, y1 int
+}
+
+func (s ellipseStroke) bounds() (cx, cy, rx, ry int) {
+	x0, x1 := s.x0, s.x1
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	y0, y1 := s.y0, s.y1
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	cx, cy = (x0+x1)/2, (y0+y1)/2
+	rx, ry = (x1-x0)/2, (y1-y0)/2
+	if rx < 1 {
+		rx = 1
+	}
+	if ry < 1 {
+		ry = 1
+	}
+	return
+}
+
+func (s ellipseStroke) eachPixel(f func(x, y int)) {
+	cx, cy, rx, ry := s.bounds()
+	plot := func(x, y int) {
+		f(cx+x, cy+y)
+		f(cx-x, cy+y)
+		f(cx+x, cy-y)
+		f(cx-x, cy-y)
+	}
+
+	rx2, ry2 := rx*rx, ry*ry
+	x, y := 0, ry
+	plot(x, y)
+
+	dx, dy := 2*ry2*x, 2*rx2*y
+	d1 := float64(ry2) - float64(rx2*ry) + 0.25*float64(rx2)
+	for dx < dy {
+		x++
+		dx += 2 * ry2
+		if d1 < 0 {
+			d1 += float64(dx) + float64(ry2)
+		} else {
+			y--
+			dy -= 2 * rx2
+			d1 += float64(dx-dy) + float64(ry2)
+		}
+		plot(x, y)
+	}
+
+	d2 := float64(ry2)*(float64(x)+0.5)*(float64(x)+0.5) + float64(rx2)*float64(y-1)*float64(y-1) - float64(rx2*ry2)
+	for y > 0 {
+		y--
+		dy -= 2 * rx2
+		if d2 > 0 {
+			d2 += float64(rx2) - float64(dy)
+		} else {
+			x++
+			dx += 2 * ry2
+			d2 += float64(dx-dy) + float64(rx2)
+		}
+		plot(x, y)
+	}
+}
+
+func (s ellipseStroke) Draw(img *image.RGBA, clr color.RGBA) {
+	s.eachPixel(func(x, y int) { img.SetRGBA(x, y, clr) })
+}
+
+func (s ellipseStroke) CopyRegion(dst, src *image.RGBA) {
+	s.eachPixel(func(x, y int) { dst.SetRGBA(x, y, src.RGBAAt(x, y)) })
+}
+
+func (s ellipseStroke) Diff(a, b *image.RGBA) float64 {
+	var dif float64
+	s.eachPixel(func(x, y int) { dif += calcDiff(a, b, x, y) })
+	return dif
+}
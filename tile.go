@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+var workers int
+var tileSize int
+
+func init() {
+	flag.IntVar(&workers, "workers", 1, "number of tile `worker`s for tile-parallel iteration (1 = single-threaded)")
+	flag.IntVar(&tileSize, "tile", 64, "tile `size`, in pixels, for -workers > 1")
+}
+
+// tileSafeShape reports whether -shape's footprint stays within the
+// single pixel it's centered on, so tileWorker's "never write outside
+// its own tile" guarantee actually holds. thick (perpendicular offset),
+// aa (Wu's algorithm plots a neighboring scanline) and ellipse (plotted
+// points can fall outside their own bounding box's tile) all reach past
+// a tile's border into a neighbor worker's region; only line and rect
+// confine every pixel they touch to their own bbox.
+func tileSafeShape() bool {
+	return shape == "line" || shape == "rect"
+}
+
+// tilesFor partitions bounds into a grid of size x size tiles; the tiles
+// along the right and bottom edges are clipped to bounds.
+func tilesFor(bounds image.Rectangle, size int) []image.Rectangle {
+	var tiles []image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += size {
+		for x := bounds.Min.X; x < bounds.Max.X; x += size {
+			r := image.Rect(x, y, x+size, y+size).Intersect(bounds)
+			tiles = append(tiles, r)
+		}
+	}
+	return tiles
+}
+
+const (
+	tileRoundSize = 5000 // iterations each worker runs before the seam barrier
+	tileSeamIters = 500  // seam-repair iterations run per round
+)
+
+// sketchTiles is the tile-parallel counterpart of sketch's main loop. It
+// partitions img into a grid of tileSize tiles and gives each of the
+// workers worker goroutines a share of those tiles, its own RNG, and
+// exclusive ownership of img1/img2 within its tiles' bounds, so workers
+// never write to the same pixel and need no locking. Proposed strokes
+// are confined to a single tile's bounding box; after each round, a
+// single-threaded seam-reconciliation pass proposes strokes that cross
+// tile borders against the whole image, since no worker can safely
+// evaluate those alone. Per-worker RNGs are derived from frameRNG, the
+// frame's own seeded rng, so a run stays reproducible for a given frame
+// and -seed; tile-parallel runs do not support -resume (sketch rejects
+// that combination before calling here, since this loop has no
+// iterStart to resume from).
+//
+// Callers must check tileSafeShape first: the no-shared-pixel guarantee
+// only holds for shapes whose footprint can't reach past its own tile.
+func sketchTiles(img, img1, img2 *image.RGBA, palette []color.RGBA, sal *saliencyMap, frameRNG *rng) {
+	bounds := img.Bounds()
+	tiles := tilesFor(bounds, tileSize)
+
+	assigned := make([][]image.Rectangle, workers)
+	for i, t := range tiles {
+		w := i % workers
+		assigned[w] = append(assigned[w], t)
+	}
+
+	rngs := make([]*rng, workers)
+	for i := range rngs {
+		rngs[i] = newRNG(frameRNG.next())
+	}
+
+	lastSaveTime := time.Now()
+	lastStatTime := time.Now()
+	var stati, statc, done int
+
+	for done < maxIter || maxIter < 0 {
+		round := tileRoundSize
+		if maxIter >= 0 && maxIter-done < round {
+			round = maxIter - done
+		}
+		if round <= 0 {
+			break
+		}
+
+		counts := make([]int, workers)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			if len(assigned[w]) == 0 {
+				continue
+			}
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				counts[w] = tileWorker(img, img1, img2, palette, assigned[w], sal, rngs[w], round)
+			}(w)
+		}
+		wg.Wait()
+
+		roundStati := round * workers
+		roundStatc := 0
+		for _, c := range counts {
+			roundStatc += c
+		}
+
+		seamC := reconcileSeams(img, img1, img2, palette, sal, frameRNG, tileSeamIters)
+		roundStati += tileSeamIters
+		roundStatc += seamC
+
+		done += round
+		stati += roundStati
+		statc += roundStatc
+
+		now := time.Now()
+		dur := now.Sub(lastSaveTime)
+		if saveDelay > 0 && dur >= time.Duration(saveDelay)*time.Second {
+			save(img2, fmt.Sprintf("incr%03d", incrSaveNum))
+			incrSaveNum++
+			lastSaveTime = time.Now()
+		}
+		dur = now.Sub(lastStatTime)
+		if dur >= time.Duration(statDelay)*time.Second {
+			ips := float64(stati) / dur.Seconds()
+			cps := float64(statc) / dur.Seconds()
+			log.Printf("%8d iters %10.2f iter/s %9.2f converg/s %6.2f%% c/i\n", done, ips, cps, 100*cps/ips)
+			stati = 0
+			statc = 0
+			lastStatTime = time.Now()
+		}
+	}
+}
+
+// tileWorker runs n proposals, each confined to one of tiles chosen at
+// random, and returns how many converged. When sal is non-nil, -saliency
+// and -tangent bias placement and orientation the same way the
+// single-threaded loop does, sampled within the chosen tile so workers
+// still never touch a neighbor's pixels.
+func tileWorker(img, img1, img2 *image.RGBA, palette []color.RGBA, tiles []image.Rectangle, sal *saliencyMap, rnd *rng, n int) int {
+	converged := 0
+	for i := 0; i < n; i++ {
+		r := tiles[rnd.Intn(len(tiles))]
+		tw, th := r.Dx(), r.Dy()
+		if tw == 0 || th == 0 {
+			continue
+		}
+
+		var x1, y1 int
+		if sal != nil && rnd.Float64() < saliencyWeight {
+			x1, y1 = sal.sampleIn(r, rnd)
+		} else {
+			x1 = r.Min.X + rnd.Intn(tw)
+			y1 = r.Min.Y + rnd.Intn(th)
+		}
+
+		var x2, y2 int
+		if tangentBias && sal != nil {
+			tx, ty := sal.tangent(x1, y1)
+			length := float64(rnd.Intn(lineLen) - lineLen/2)
+			x2 = clampInt(x1+int(math.Round(tx*length)), r.Min.X, r.Max.X-1)
+			y2 = clampInt(y1+int(math.Round(ty*length)), r.Min.Y, r.Max.Y-1)
+		} else {
+			x2 = clampInt(x1-lineLen/2+rnd.Intn(lineLen), r.Min.X, r.Max.X-1)
+			y2 = clampInt(y1-lineLen/2+rnd.Intn(lineLen), r.Min.Y, r.Max.Y-1)
+		}
+		clr := palette[rnd.Intn(len(palette))]
+
+		s := newStroke(x1, y1, x2, y2)
+		s.Draw(img1, clr)
+		if s.Diff(img, img1) < s.Diff(img, img2) {
+			s.CopyRegion(img2, img1)
+			converged++
+		} else {
+			s.CopyRegion(img1, img2)
+		}
+	}
+	return converged
+}
+
+// reconcileSeams proposes n strokes anchored near tile borders against
+// the whole image, repairing strokes that tileWorker could never have
+// proposed because they would have crossed into a neighboring tile.
+// Each proposal anchors on a vertical tile-column boundary or a
+// horizontal tile-row boundary with equal probability, since tiles
+// share both kinds of border with their neighbors.
+func reconcileSeams(img, img1, img2 *image.RGBA, palette []color.RGBA, sal *saliencyMap, rnd *rng, n int) int {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	converged := 0
+	for i := 0; i < n; i++ {
+		var x1, y1 int
+		if rnd.Intn(2) == 0 {
+			bx := bounds.Min.X + (rnd.Intn(w/tileSize+1))*tileSize
+			x1 = clampInt(bx-lineLen/2+rnd.Intn(lineLen), bounds.Min.X, bounds.Max.X-1)
+			y1 = bounds.Min.Y + rnd.Intn(h)
+		} else {
+			by := bounds.Min.Y + (rnd.Intn(h/tileSize+1))*tileSize
+			y1 = clampInt(by-lineLen/2+rnd.Intn(lineLen), bounds.Min.Y, bounds.Max.Y-1)
+			x1 = bounds.Min.X + rnd.Intn(w)
+		}
+
+		var x2, y2 int
+		if tangentBias && sal != nil {
+			tx, ty := sal.tangent(x1, y1)
+			length := float64(rnd.Intn(lineLen) - lineLen/2)
+			x2 = clampInt(x1+int(math.Round(tx*length)), bounds.Min.X, bounds.Max.X-1)
+			y2 = clampInt(y1+int(math.Round(ty*length)), bounds.Min.Y, bounds.Max.Y-1)
+		} else {
+			x2 = clampInt(x1-lineLen/2+rnd.Intn(lineLen), bounds.Min.X, bounds.Max.X-1)
+			y2 = clampInt(y1-lineLen/2+rnd.Intn(lineLen), bounds.Min.Y, bounds.Max.Y-1)
+		}
+		clr := palette[rnd.Intn(len(palette))]
+
+		s := newStroke(x1, y1, x2, y2)
+		s.Draw(img1, clr)
+		if s.Diff(img, img1) < s.Diff(img, img2) {
+			s.CopyRegion(img2, img1)
+			converged++
+		} else {
+			s.CopyRegion(img1, img2)
+		}
+	}
+	return converged
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}